@@ -0,0 +1,320 @@
+package mixcoin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const leafSize = sha256.Size
+
+// merkleTree is an append-only, SHA-256 binary Merkle tree backing the
+// accountability log: every signed warranty gets a leaf, so a third
+// party can later prove the service issued a given warranty (inclusion
+// proof), or that the log only ever grew (consistency proof).
+type merkleTree struct {
+	mu       sync.Mutex
+	leaves   [][]byte
+	frontier [][]byte // right-edge hash per level awaiting a sibling; nil means no pending subtree of that size
+	file     *os.File
+}
+
+// newMerkleTree loads an existing log from path, if any, and opens it
+// for further appends.
+func newMerkleTree(path string) (*merkleTree, error) {
+	t := &merkleTree{}
+
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading accountability log %s: %v", path, err)
+	}
+	for i := 0; i+leafSize <= len(existing); i += leafSize {
+		t.appendLeaf(append([]byte(nil), existing[i:i+leafSize]...))
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening accountability log %s: %v", path, err)
+	}
+	t.file = f
+
+	return t, nil
+}
+
+// leafHash and nodeHash use RFC 6962's domain separation prefixes
+// (0x00 for leaves, 0x01 for internal nodes) so a leaf hash can never be
+// replayed as an internal node hash or vice versa.
+func leafHash(data []byte) []byte {
+	sum := sha256.Sum256(append([]byte{0x00}, data...))
+	return sum[:]
+}
+
+// WarrantyLeafHash returns the accountability-log leaf hash for the exact
+// bytes of a signed warranty, i.e. the leafHash query param
+// inclusionProofHandler expects. It's exported so a client holding only
+// its own warranty (the JSON encoding of the chunk response's
+// ChunkMessage) can compute
+// it itself rather than needing to read the unexported hashing scheme.
+func WarrantyLeafHash(warrantyBytes []byte) []byte {
+	return leafHash(warrantyBytes)
+}
+
+func nodeHash(left, right []byte) []byte {
+	buf := append([]byte{0x01}, left...)
+	buf = append(buf, right...)
+	sum := sha256.Sum256(buf)
+	return sum[:]
+}
+
+func emptyHash() []byte {
+	sum := sha256.Sum256(nil)
+	return sum[:]
+}
+
+// Append hashes data, persists the leaf, and returns its index.
+func (t *merkleTree) Append(data []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	hash := leafHash(data)
+	if _, err := t.file.Write(hash); err != nil {
+		return 0, fmt.Errorf("error persisting accountability log entry: %v", err)
+	}
+
+	return t.appendLeaf(hash), nil
+}
+
+// appendLeaf adds an already-hashed leaf to the in-memory tree, updating
+// the frontier in O(log n). Caller must hold t.mu.
+func (t *merkleTree) appendLeaf(hash []byte) int {
+	index := len(t.leaves)
+	t.leaves = append(t.leaves, hash)
+
+	level := 0
+	for level < len(t.frontier) && t.frontier[level] != nil {
+		hash = nodeHash(t.frontier[level], hash)
+		t.frontier[level] = nil
+		level++
+	}
+	if level == len(t.frontier) {
+		t.frontier = append(t.frontier, hash)
+	} else {
+		t.frontier[level] = hash
+	}
+
+	return index
+}
+
+func (t *merkleTree) Size() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.leaves)
+}
+
+// Root returns the current root hash, computed from the frontier in
+// O(log n) rather than rehashing every leaf.
+func (t *merkleTree) Root() []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rootFromFrontier()
+}
+
+// rootFromFrontier folds the frontier's complete subtrees from the
+// smallest (latest) up to the largest (earliest), each larger subtree
+// becoming the left child, which is exactly MTH(D) for the RFC 6962
+// tree hash. Caller must hold t.mu.
+func (t *merkleTree) rootFromFrontier() []byte {
+	var hash []byte
+	for level := 0; level < len(t.frontier); level++ {
+		if t.frontier[level] == nil {
+			continue
+		}
+		if hash == nil {
+			hash = t.frontier[level]
+		} else {
+			hash = nodeHash(t.frontier[level], hash)
+		}
+	}
+	if hash == nil {
+		return emptyHash()
+	}
+	return hash
+}
+
+// InclusionProof finds leaf among the logged warranties and returns its
+// index and audit path (sibling hashes from the leaf up to the root).
+func (t *merkleTree) InclusionProof(leaf []byte) (index int, path [][]byte, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	index = -1
+	for i, l := range t.leaves {
+		if bytes.Equal(l, leaf) {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return 0, nil, fmt.Errorf("leaf not found in accountability log")
+	}
+
+	return index, inclusionPath(t.leaves, index), nil
+}
+
+// ConsistencyProof returns the classic CT-style proof that the tree of
+// size oldSize is a prefix of the tree of size newSize.
+func (t *merkleTree) ConsistencyProof(oldSize, newSize int) ([][]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if oldSize < 0 || oldSize > newSize || newSize > len(t.leaves) {
+		return nil, fmt.Errorf("invalid sizes for consistency proof: old=%d new=%d tree=%d", oldSize, newSize, len(t.leaves))
+	}
+	if oldSize == 0 || oldSize == newSize {
+		return nil, nil
+	}
+	return subProof(t.leaves[:newSize], oldSize, true), nil
+}
+
+// mth is RFC 6962's Merkle Tree Hash: split at the largest power of two
+// less than n and combine recursively. Unlike the naive "duplicate the
+// last node" construction, this never pairs a node with itself, which
+// is what makes subProof/inclusionPath's proofs verify.
+func mth(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		return emptyHash()
+	case 1:
+		return leaves[0]
+	}
+	k := largestPowerOfTwoLessThan(len(leaves))
+	return nodeHash(mth(leaves[:k]), mth(leaves[k:]))
+}
+
+func largestPowerOfTwoLessThan(n int) int {
+	k := 1
+	for k*2 < n {
+		k *= 2
+	}
+	return k
+}
+
+// inclusionPath implements RFC 6962's PATH algorithm: the sibling hash
+// at each level from leaf m up to the root.
+func inclusionPath(leaves [][]byte, m int) [][]byte {
+	n := len(leaves)
+	if n <= 1 {
+		return nil
+	}
+
+	k := largestPowerOfTwoLessThan(n)
+	if m < k {
+		return append(inclusionPath(leaves[:k], m), mth(leaves[k:]))
+	}
+	return append(inclusionPath(leaves[k:], m-k), mth(leaves[:k]))
+}
+
+// subProof implements RFC 6962's SUBPROOF algorithm.
+func subProof(leaves [][]byte, m int, b bool) [][]byte {
+	n := len(leaves)
+	if m == n {
+		if b {
+			return nil
+		}
+		return [][]byte{mth(leaves)}
+	}
+
+	k := largestPowerOfTwoLessThan(n)
+	if m <= k {
+		return append(subProof(leaves[:k], m, b), mth(leaves[k:]))
+	}
+	return append(subProof(leaves[k:], m-k, false), mth(leaves[:k]))
+}
+
+type signedTreeHead struct {
+	TreeSize  int    `json:"treeSize"`
+	RootHash  string `json:"rootHash"`
+	Signature string `json:"signature"`
+}
+
+// getSTH returns the current tree size and root hash, PGP-signed with
+// the same entity that signs warranties.
+func getSTH() *signedTreeHead {
+	size := auditLog.Size()
+	rootHex := hex.EncodeToString(auditLog.Root())
+	canonical := fmt.Sprintf("%d:%s", size, rootHex)
+
+	return &signedTreeHead{
+		TreeSize:  size,
+		RootHash:  rootHex,
+		Signature: signText(getPgpEntity(), canonical),
+	}
+}
+
+func sthHandler(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, getSTH())
+}
+
+type inclusionProofResponse struct {
+	LeafIndex int      `json:"leafIndex"`
+	AuditPath []string `json:"auditPath"`
+}
+
+func inclusionProofHandler(w http.ResponseWriter, r *http.Request) {
+	leaf, err := hex.DecodeString(r.URL.Query().Get("leafHash"))
+	if err != nil {
+		http.Error(w, "invalid leafHash", http.StatusBadRequest)
+		return
+	}
+
+	index, path, err := auditLog.InclusionProof(leaf)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	writeJson(w, &inclusionProofResponse{LeafIndex: index, AuditPath: hexAll(path)})
+}
+
+type consistencyProofResponse struct {
+	Proof []string `json:"proof"`
+}
+
+func consistencyProofHandler(w http.ResponseWriter, r *http.Request) {
+	oldSize, err1 := strconv.Atoi(r.URL.Query().Get("oldSize"))
+	newSize, err2 := strconv.Atoi(r.URL.Query().Get("newSize"))
+	if err1 != nil || err2 != nil {
+		http.Error(w, "invalid oldSize/newSize", http.StatusBadRequest)
+		return
+	}
+
+	proof, err := auditLog.ConsistencyProof(oldSize, newSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJson(w, &consistencyProofResponse{Proof: hexAll(proof)})
+}
+
+func hexAll(hashes [][]byte) []string {
+	out := make([]string, len(hashes))
+	for i, h := range hashes {
+		out[i] = hex.EncodeToString(h)
+	}
+	return out
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error encoding response: %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package mixcoin
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// verifyConsistency independently re-derives the old and new roots from a
+// consistency proof, mirroring subProof's recursion, and checks them
+// against the roots the server actually published.
+func verifyConsistency(proof [][]byte, first, second int, firstRoot, secondRoot []byte) bool {
+	if first == second {
+		return len(proof) == 0 && bytes.Equal(firstRoot, secondRoot)
+	}
+
+	oldRoot, newRoot := reconsistency(&proof, second, first, true, firstRoot)
+	return len(proof) == 0 && bytes.Equal(oldRoot, firstRoot) && bytes.Equal(newRoot, secondRoot)
+}
+
+// reconsistency is the inverse of subProof: given n (the current
+// subtree's leaf count) and m (the old tree's boundary within it), it
+// consumes proof elements in the same order subProof produced them and
+// returns that subtree's old and new root.
+func reconsistency(proof *[][]byte, n, m int, b bool, seedOldRoot []byte) (oldRoot, newRoot []byte) {
+	if m == n {
+		if b {
+			return seedOldRoot, seedOldRoot
+		}
+		h := popProof(proof)
+		return h, h
+	}
+
+	k := largestPowerOfTwoLessThan(n)
+	if m <= k {
+		oldL, newL := reconsistency(proof, k, m, b, seedOldRoot)
+		right := popProof(proof)
+		return oldL, nodeHash(newL, right)
+	}
+
+	oldR, newR := reconsistency(proof, n-k, m-k, false, seedOldRoot)
+	left := popProof(proof)
+	return nodeHash(left, oldR), nodeHash(left, newR)
+}
+
+func popProof(proof *[][]byte) []byte {
+	h := (*proof)[0]
+	*proof = (*proof)[1:]
+	return h
+}
+
+// inclusionStep records, for one level of inclusionPath's recursion,
+// which side of the split the target leaf fell on.
+type inclusionStep struct {
+	isLeft bool
+}
+
+// inclusionSteps walks the same recursion inclusionPath does, top level
+// first, recording which side m fell on at each split. inclusionPath
+// appends its own level's sibling *after* recursing, so its returned
+// path is ordered innermost-first; this is ordered outermost-first, the
+// reverse.
+func inclusionSteps(n, m int) []inclusionStep {
+	if n <= 1 {
+		return nil
+	}
+	k := largestPowerOfTwoLessThan(n)
+	if m < k {
+		return append([]inclusionStep{{isLeft: true}}, inclusionSteps(k, m)...)
+	}
+	return append([]inclusionStep{{isLeft: false}}, inclusionSteps(n-k, m-k)...)
+}
+
+// verifyInclusion independently re-derives the root an inclusion proof
+// claims leaf belongs to. path is ordered innermost-first (as
+// inclusionPath produces it), so it's walked in reverse to go from the
+// leaf up to the root.
+func verifyInclusion(leaf []byte, index, size int, path [][]byte, root []byte) bool {
+	steps := inclusionSteps(size, index)
+	if len(steps) != len(path) {
+		return false
+	}
+
+	hash := leaf
+	for i := len(steps) - 1; i >= 0; i-- {
+		sibling := path[len(steps)-1-i]
+		if steps[i].isLeft {
+			hash = nodeHash(hash, sibling)
+		} else {
+			hash = nodeHash(sibling, hash)
+		}
+	}
+
+	return bytes.Equal(hash, root)
+}
+
+// TestMerkleConsistency appends leaves one at a time and checks, after
+// every append, that the new root is consistent with every earlier root
+// via ConsistencyProof, and that every leaf logged so far still produces
+// a valid InclusionProof against the current root.
+func TestMerkleConsistency(t *testing.T) {
+	tree, err := newMerkleTree(filepath.Join(t.TempDir(), "audit.merkle"))
+	if err != nil {
+		t.Fatalf("newMerkleTree: %v", err)
+	}
+
+	const n = 9
+	var roots [][]byte
+	var leaves [][]byte
+
+	for i := 0; i < n; i++ {
+		data := []byte(fmt.Sprintf("warranty-%d", i))
+		if _, err := tree.Append(data); err != nil {
+			t.Fatalf("Append(%d): %v", i, err)
+		}
+		leaves = append(leaves, leafHash(data))
+		roots = append(roots, tree.Root())
+
+		for old := 0; old <= i; old++ {
+			proof, err := tree.ConsistencyProof(old+1, i+1)
+			if err != nil {
+				t.Fatalf("ConsistencyProof(%d, %d): %v", old+1, i+1, err)
+			}
+			if !verifyConsistency(proof, old+1, i+1, roots[old], roots[i]) {
+				t.Fatalf("consistency proof from size %d to %d did not verify", old+1, i+1)
+			}
+		}
+
+		for j, leaf := range leaves {
+			index, path, err := tree.InclusionProof(leaf)
+			if err != nil {
+				t.Fatalf("InclusionProof(%d): %v", j, err)
+			}
+			if index != j {
+				t.Fatalf("InclusionProof(%d) returned index %d", j, index)
+			}
+			if !verifyInclusion(leaf, index, i+1, path, roots[i]) {
+				t.Fatalf("inclusion proof for leaf %d against tree size %d did not verify", j, i+1)
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package mixcoin
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"net/http"
+
+	"github.com/conformal/btcutil"
+)
+
+// authRole orders the credential tiers from least to most privileged so
+// requireAuth can do a simple numeric comparison.
+type authRole int
+
+const (
+	roleNone authRole = iota
+	roleLimited
+	roleAdmin
+)
+
+// rpcAuth holds the precomputed hashes of the expected Authorization
+// headers for each configured role.
+type rpcAuth struct {
+	adminHash   [sha256.Size]byte
+	hasAdmin    bool
+	limitedHash [sha256.Size]byte
+	hasLimited  bool
+}
+
+// newRpcAuth requires an admin user/pass to be configured; without one,
+// every requireAuth-wrapped handler (including /chunk) would reject
+// every request forever with no way to tell why, so refuse to start
+// instead.
+func newRpcAuth(cfg *Config) *rpcAuth {
+	if cfg.RpcUser == "" {
+		log.Fatalf("no RpcUser/RpcPass configured; set them in %s", defaultConfigFile)
+	}
+
+	auth := &rpcAuth{
+		adminHash: authHeaderHash(cfg.RpcUser, cfg.RpcPass),
+		hasAdmin:  true,
+	}
+	if cfg.RpcLimitUser != "" {
+		auth.limitedHash = authHeaderHash(cfg.RpcLimitUser, cfg.RpcLimitPass)
+		auth.hasLimited = true
+	}
+	return auth
+}
+
+func authHeaderHash(user, pass string) [sha256.Size]byte {
+	token := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+	return sha256.Sum256([]byte(token))
+}
+
+// authenticate compares r's Authorization header against the configured
+// admin and limited credentials in constant time, so a byte-by-byte
+// timing difference can't leak which part of the password is wrong.
+func (a *rpcAuth) authenticate(r *http.Request) authRole {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return roleNone
+	}
+	headerHash := sha256.Sum256([]byte(header))
+
+	if a.hasAdmin && subtle.ConstantTimeCompare(headerHash[:], a.adminHash[:]) == 1 {
+		return roleAdmin
+	}
+	if a.hasLimited && subtle.ConstantTimeCompare(headerHash[:], a.limitedHash[:]) == 1 {
+		return roleLimited
+	}
+	return roleNone
+}
+
+// requireAuth wraps handler so it only runs once the request has
+// authenticated as at least minRole, returning 401 with a
+// WWW-Authenticate header otherwise. Connections that never present
+// credentials are cut off by serveChunkRequests' ReadHeaderTimeout
+// rather than here.
+func requireAuth(minRole authRole, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if role := rpcCreds.authenticate(r); role >= minRole {
+			handler(w, r)
+			return
+		}
+
+		log.Printf("rejecting unauthorized %s request for %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Basic realm="mixcoin"`)
+		http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+	}
+}
+
+type statusResponse struct {
+	BlockchainHeight int            `json:"blockchainHeight"`
+	AuditLogSize     int            `json:"auditLogSize"`
+	ReserveCount     int            `json:"reserveCount"`
+	ReserveTotal     btcutil.Amount `json:"reserveTotal"`
+}
+
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	reserveCount, reserveTotal := pool.ReserveSummary()
+	writeJson(w, &statusResponse{
+		BlockchainHeight: blockchainHeight,
+		AuditLogSize:     auditLog.Size(),
+		ReserveCount:     reserveCount,
+		ReserveTotal:     reserveTotal,
+	})
+}
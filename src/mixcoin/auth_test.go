@@ -0,0 +1,98 @@
+package mixcoin
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func basicAuthHeader(user, pass string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+}
+
+// TestAuthenticate verifies that authenticate grants the role matching
+// whichever configured credential was presented, and roleNone otherwise.
+func TestAuthenticate(t *testing.T) {
+	auth := &rpcAuth{
+		adminHash:   authHeaderHash("admin", "adminpass"),
+		hasAdmin:    true,
+		limitedHash: authHeaderHash("limited", "limitedpass"),
+		hasLimited:  true,
+	}
+
+	cases := []struct {
+		name   string
+		header string
+		want   authRole
+	}{
+		{"no header", "", roleNone},
+		{"admin creds", basicAuthHeader("admin", "adminpass"), roleAdmin},
+		{"limited creds", basicAuthHeader("limited", "limitedpass"), roleLimited},
+		{"wrong creds", basicAuthHeader("admin", "wrongpass"), roleNone},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/status", nil)
+		if c.header != "" {
+			r.Header.Set("Authorization", c.header)
+		}
+		if got := auth.authenticate(r); got != c.want {
+			t.Errorf("%s: authenticate() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// TestRequireAuth verifies that requireAuth lets through requests that
+// authenticate at or above minRole and rejects everything else with
+// 401. This is the gate newRpcAuth's fail-fast startup check exists to
+// protect: with no admin credentials configured, every request here
+// would fall through to the 401 branch for minRole roleLimited or
+// roleAdmin.
+func TestRequireAuth(t *testing.T) {
+	rpcCreds = &rpcAuth{
+		adminHash:   authHeaderHash("admin", "adminpass"),
+		hasAdmin:    true,
+		limitedHash: authHeaderHash("limited", "limitedpass"),
+		hasLimited:  true,
+	}
+
+	limitedHandler := requireAuth(roleLimited, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	admin := httptest.NewRequest("GET", "/status", nil)
+	admin.Header.Set("Authorization", basicAuthHeader("admin", "adminpass"))
+	w := httptest.NewRecorder()
+	limitedHandler(w, admin)
+	if w.Code != http.StatusOK {
+		t.Errorf("admin creds against roleLimited handler = %d, want 200", w.Code)
+	}
+
+	limited := httptest.NewRequest("GET", "/status", nil)
+	limited.Header.Set("Authorization", basicAuthHeader("limited", "limitedpass"))
+	w = httptest.NewRecorder()
+	limitedHandler(w, limited)
+	if w.Code != http.StatusOK {
+		t.Errorf("limited creds against roleLimited handler = %d, want 200", w.Code)
+	}
+
+	none := httptest.NewRequest("GET", "/status", nil)
+	w = httptest.NewRecorder()
+	limitedHandler(w, none)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("no creds against roleLimited handler = %d, want 401", w.Code)
+	}
+	if got := w.Header().Get("WWW-Authenticate"); got == "" {
+		t.Errorf("401 response missing WWW-Authenticate header")
+	}
+
+	adminHandler := requireAuth(roleAdmin, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	w = httptest.NewRecorder()
+	adminHandler(w, limited)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("limited creds against roleAdmin handler = %d, want 401", w.Code)
+	}
+}
@@ -0,0 +1,80 @@
+package mixcoin
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+)
+
+const defaultConfigFile = "mixcoin.conf"
+
+// Config holds the runtime configuration for the mixcoin server. It is
+// loaded once at startup via GetConfig and stashed in the package-level
+// cfg variable.
+type Config struct {
+	DbFile           string
+	ListenAddr       string
+	MinConfirmations int
+	ChunkSize        int64
+
+	// RpcCert and RpcKey point at the PEM-encoded TLS certificate and key
+	// used to serve chunk requests. If either file is missing on startup
+	// a self-signed keypair is generated and written to these paths.
+	RpcCert string
+	RpcKey  string
+
+	// DisableTls serves chunk requests over plain HTTP. Only intended for
+	// local testing.
+	DisableTls bool
+
+	// WsAuthTimeoutSeconds is how long an unauthenticated websocket
+	// notification connection is kept open waiting for a subscribe
+	// message before it's closed.
+	WsAuthTimeoutSeconds int
+
+	// RpcUser/RpcPass gate handleChunkRequest and the rest of the API,
+	// except the /ws notification endpoint, which is not behind Basic auth.
+	// RpcLimitUser/RpcLimitPass, if set, gate only the read-only
+	// endpoints (GetSTH, status, the proof endpoints).
+	RpcUser      string
+	RpcPass      string
+	RpcLimitUser string
+	RpcLimitPass string
+
+	// RpcAuthTimeoutSeconds bounds how long a connection may take to
+	// send its request headers, including the Authorization header,
+	// before it's closed.
+	RpcAuthTimeoutSeconds int
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		DbFile:                "mixcoin.db",
+		ListenAddr:            ":8775",
+		MinConfirmations:      1,
+		ChunkSize:             1000000,
+		RpcCert:               "rpc.cert",
+		RpcKey:                "rpc.key",
+		DisableTls:            false,
+		WsAuthTimeoutSeconds:  30,
+		RpcAuthTimeoutSeconds: 10,
+	}
+}
+
+// GetConfig loads the mixcoin config from defaultConfigFile, if present,
+// layered on top of the built-in defaults.
+func GetConfig() *Config {
+	c := defaultConfig()
+
+	f, err := os.Open(defaultConfigFile)
+	if err != nil {
+		log.Printf("no config file found at %s, using defaults", defaultConfigFile)
+		return c
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(c); err != nil {
+		log.Panicf("error parsing config file %s: %v", defaultConfigFile, err)
+	}
+	return c
+}
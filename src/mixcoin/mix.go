@@ -5,7 +5,7 @@ import (
 	"time"
 )
 
-func mix(delay int, outAddr string) {
+func mix(mixAddr string, delay int, outAddr string) {
 	log.Printf("mixing chunk to address: %s", outAddr)
 	log.Printf("waiting %d blocks", delay)
 	time.Sleep(time.Duration(delay) * 10 * time.Minute)
@@ -20,6 +20,7 @@ func mix(delay int, outAddr string) {
 	if err != nil {
 		log.Panicf("error sending chunk: ", err)
 	}
+	wsCtx.notifyChunkSent(mixAddr, outAddr, outputChunk.TxId, outputChunk.Vout)
 }
 
 func generateDelay(returnBy int) int {
@@ -0,0 +1,192 @@
+package mixcoin
+
+import (
+	"log"
+	"sync"
+
+	"github.com/conformal/btcutil"
+)
+
+// PoolState is the lifecycle stage of a pool item.
+type PoolState int
+
+const (
+	Receivable PoolState = iota
+	Mixing
+	Reserve
+)
+
+// Outpoint identifies a specific unspent transaction output.
+type Outpoint struct {
+	TxId string `json:"txid"`
+	Vout int    `json:"vout"`
+}
+
+// Utxo is a confirmed unspent output received at a mix address. It
+// carries the outpoint so multiple outputs landing on the same address
+// (overpayment, dust spam, address reuse) are tracked independently
+// instead of clobbering one another.
+type Utxo struct {
+	Outpoint Outpoint       `json:"outpoint"`
+	Addr     string         `json:"addr"`
+	Amount   btcutil.Amount `json:"amount"`
+	Height   int            `json:"height"`
+}
+
+// PoolItem is either a *ChunkMessage (while Receivable, awaiting funds)
+// or a *Utxo (once funds have arrived and moved to Mixing or Reserve).
+type PoolItem interface{}
+
+// PoolManager tracks chunk requests as they move from an unfunded
+// warranty to a funded, outpoint-identified UTXO and on into mixing or
+// fee reserve.
+type PoolManager interface {
+	// Put files item under state. *ChunkMessage items must use
+	// Receivable; *Utxo items may use Mixing or Reserve, and moving a
+	// previously-filed outpoint to a new state supersedes its old one.
+	Put(state PoolState, item PoolItem)
+
+	// ReceivingKeys returns the mix addresses with a chunk message still
+	// awaiting funds.
+	ReceivingKeys() []string
+
+	// Scan removes and returns the chunk messages registered at addrs.
+	Scan(addrs []string) []PoolItem
+
+	// Filter removes every still-Receivable chunk message for which
+	// remove returns true.
+	Filter(remove func(PoolItem) bool)
+
+	// UtxosForAddr returns every utxo currently pooled at addr,
+	// regardless of state.
+	UtxosForAddr(addr string) []*Utxo
+
+	// ReserveSummary reports how many utxos are currently held as fees
+	// and their total amount.
+	ReserveSummary() (count int, total btcutil.Amount)
+
+	Shutdown()
+}
+
+type poolManager struct {
+	mu sync.Mutex
+
+	receivable map[string]*ChunkMessage // MixAddr -> pending warranty
+
+	utxos   map[Outpoint]*Utxo
+	state   map[Outpoint]PoolState
+	byAddr  map[string][]Outpoint
+	byState map[PoolState][]Outpoint
+}
+
+func NewPoolManager() PoolManager {
+	return &poolManager{
+		receivable: make(map[string]*ChunkMessage),
+		utxos:      make(map[Outpoint]*Utxo),
+		state:      make(map[Outpoint]PoolState),
+		byAddr:     make(map[string][]Outpoint),
+		byState:    make(map[PoolState][]Outpoint),
+	}
+}
+
+func (p *poolManager) Put(state PoolState, item PoolItem) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch v := item.(type) {
+	case *ChunkMessage:
+		if state != Receivable {
+			log.Panicf("chunk message %+v cannot be filed under state %d", v, state)
+		}
+		p.receivable[v.MixAddr] = v
+	case *Utxo:
+		p.putUtxo(state, v)
+	default:
+		log.Panicf("unsupported pool item type %T", item)
+	}
+}
+
+func (p *poolManager) putUtxo(state PoolState, utxo *Utxo) {
+	op := utxo.Outpoint
+	if old, ok := p.state[op]; ok {
+		removeOutpoint(p.byState, old, op)
+	} else {
+		p.byAddr[utxo.Addr] = append(p.byAddr[utxo.Addr], op)
+	}
+
+	p.utxos[op] = utxo
+	p.state[op] = state
+	p.byState[state] = append(p.byState[state], op)
+}
+
+func removeOutpoint(byState map[PoolState][]Outpoint, state PoolState, op Outpoint) {
+	ops := byState[state]
+	for i, o := range ops {
+		if o == op {
+			byState[state] = append(ops[:i], ops[i+1:]...)
+			return
+		}
+	}
+}
+
+func (p *poolManager) ReceivingKeys() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keys := make([]string, 0, len(p.receivable))
+	for addr := range p.receivable {
+		keys = append(keys, addr)
+	}
+	return keys
+}
+
+func (p *poolManager) Scan(addrs []string) []PoolItem {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var found []PoolItem
+	for _, addr := range addrs {
+		if msg, ok := p.receivable[addr]; ok {
+			found = append(found, msg)
+			delete(p.receivable, addr)
+		}
+	}
+	return found
+}
+
+func (p *poolManager) Filter(remove func(PoolItem) bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for addr, msg := range p.receivable {
+		if remove(msg) {
+			delete(p.receivable, addr)
+		}
+	}
+}
+
+func (p *poolManager) UtxosForAddr(addr string) []*Utxo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ops := p.byAddr[addr]
+	utxos := make([]*Utxo, 0, len(ops))
+	for _, op := range ops {
+		utxos = append(utxos, p.utxos[op])
+	}
+	return utxos
+}
+
+func (p *poolManager) ReserveSummary() (count int, total btcutil.Amount) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ops := p.byState[Reserve]
+	count = len(ops)
+	for _, op := range ops {
+		total += p.utxos[op].Amount
+	}
+	return count, total
+}
+
+func (p *poolManager) Shutdown() {}
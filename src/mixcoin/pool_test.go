@@ -0,0 +1,62 @@
+package mixcoin
+
+import (
+	"testing"
+
+	"github.com/conformal/btcutil"
+)
+
+// TestPoolMultipleUtxosPerAddress verifies that a mix address receiving
+// more than one UTXO tracks each by its own outpoint rather than one
+// clobbering the other, and that moving one to a new state leaves its
+// sibling where it was.
+func TestPoolMultipleUtxosPerAddress(t *testing.T) {
+	p := NewPoolManager()
+
+	addr := "mixaddr1"
+	fulfilling := &Utxo{Outpoint: Outpoint{TxId: "tx1", Vout: 0}, Addr: addr, Amount: btcutil.Amount(1000000)}
+	extra := &Utxo{Outpoint: Outpoint{TxId: "tx2", Vout: 1}, Addr: addr, Amount: btcutil.Amount(50000)}
+
+	p.Put(Mixing, fulfilling)
+	p.Put(Reserve, extra)
+
+	pm := p.(*poolManager)
+
+	if got := pm.state[fulfilling.Outpoint]; got != Mixing {
+		t.Fatalf("fulfilling utxo state = %v, want Mixing", got)
+	}
+	if got := pm.state[extra.Outpoint]; got != Reserve {
+		t.Fatalf("extra utxo state = %v, want Reserve", got)
+	}
+
+	ops := pm.byAddr[addr]
+	if len(ops) != 2 {
+		t.Fatalf("byAddr[%s] has %d outpoints, want 2", addr, len(ops))
+	}
+
+	// Moving the extra utxo to Mixing should not disturb the fulfilling
+	// utxo's own state or address index entry.
+	p.Put(Mixing, extra)
+	if got := pm.state[extra.Outpoint]; got != Mixing {
+		t.Fatalf("extra utxo state after re-Put = %v, want Mixing", got)
+	}
+	if got := pm.state[fulfilling.Outpoint]; got != Mixing {
+		t.Fatalf("fulfilling utxo state disturbed by re-filing extra utxo: %v", got)
+	}
+	if len(pm.byState[Reserve]) != 0 {
+		t.Fatalf("byState[Reserve] still holds %d outpoints after extra utxo moved out", len(pm.byState[Reserve]))
+	}
+	if len(pm.byAddr[addr]) != 2 {
+		t.Fatalf("byAddr[%s] has %d outpoints after re-filing, want 2", addr, len(pm.byAddr[addr]))
+	}
+
+	if got := p.UtxosForAddr(addr); len(got) != 2 {
+		t.Fatalf("UtxosForAddr(%s) returned %d utxos, want 2", addr, len(got))
+	}
+
+	p.Put(Reserve, fulfilling)
+	p.Put(Reserve, extra)
+	if count, total := p.ReserveSummary(); count != 2 || total != fulfilling.Amount+extra.Amount {
+		t.Fatalf("ReserveSummary() = (%d, %v), want (2, %v)", count, total, fulfilling.Amount+extra.Amount)
+	}
+}
@@ -1,13 +1,20 @@
 package mixcoin
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
-	"math/big"
 	"math/rand"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/conformal/btcjson"
 	"github.com/conformal/btcutil"
@@ -26,6 +33,9 @@ var (
 	mix              *Mix
 	cfg              *Config
 	db               DB
+	wsCtx            *wsContext
+	auditLog         *merkleTree
+	rpcCreds         *rpcAuth
 )
 
 func init() {
@@ -41,10 +51,113 @@ func StartMixcoinServer() {
 	rpc = NewRpcClient()
 	blockchainHeight = getBlockchainHeight()
 
+	var err error
+	auditLog, err = newMerkleTree(cfg.DbFile + ".merkle")
+	if err != nil {
+		log.Panicf("error opening accountability log: %v", err)
+	}
+
 	mix = NewMix(nil)
+	wsCtx = NewWsContext(cfg.WsAuthTimeoutSeconds)
+	rpcCreds = newRpcAuth(cfg)
 	BootstrapPool()
 	LoadReserves()
 	HandleShutdown()
+
+	go func() {
+		if err := serveChunkRequests(); err != nil {
+			log.Panicf("chunk request server exited: %v", err)
+		}
+	}()
+}
+
+// serveChunkRequests listens for incoming ChunkMessage requests on
+// cfg.ListenAddr. Unless cfg.DisableTls is set, it serves over TLS,
+// generating a self-signed certificate on first run if cfg.RpcCert and
+// cfg.RpcKey don't already exist.
+func serveChunkRequests() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chunk", requireAuth(roleAdmin, chunkRequestHandler))
+	mux.Handle("/ws", wsCtx.Handler())
+	mux.HandleFunc("/sth", requireAuth(roleLimited, sthHandler))
+	mux.HandleFunc("/inclusion-proof", requireAuth(roleLimited, inclusionProofHandler))
+	mux.HandleFunc("/consistency-proof", requireAuth(roleLimited, consistencyProofHandler))
+	mux.HandleFunc("/status", requireAuth(roleLimited, statusHandler))
+	mux.HandleFunc("/pool", requireAuth(roleAdmin, poolHandler))
+
+	server := &http.Server{
+		Addr:              cfg.ListenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: time.Duration(cfg.RpcAuthTimeoutSeconds) * time.Second,
+	}
+
+	if cfg.DisableTls {
+		log.Printf("TLS disabled; serving chunk requests over plain HTTP on %s", cfg.ListenAddr)
+		return server.ListenAndServe()
+	}
+
+	if err := ensureTlsKeyPair(cfg.RpcCert, cfg.RpcKey); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("serving chunk requests over TLS on %s", cfg.ListenAddr)
+	return server.ServeTLS(listener, cfg.RpcCert, cfg.RpcKey)
+}
+
+func chunkRequestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var chunkMsg ChunkMessage
+	if err := json.NewDecoder(r.Body).Decode(&chunkMsg); err != nil {
+		http.Error(w, "malformed chunk request", http.StatusBadRequest)
+		return
+	}
+
+	leafHash, err := handleChunkRequest(&chunkMsg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	resp := chunkResponse{ChunkMessage: &chunkMsg, LeafHash: leafHash}
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
+		log.Printf("error encoding chunk response: %v", err)
+	}
+}
+
+// chunkResponse is the chunk warranty plus the accountability-log leaf
+// hash it was logged under, so the client can query /inclusion-proof
+// without needing to know the log's internal hashing scheme.
+type chunkResponse struct {
+	*ChunkMessage
+	LeafHash string `json:"leafHash"`
+}
+
+type poolResponse struct {
+	Utxos []*Utxo `json:"utxos"`
+}
+
+// poolHandler is an admin support tool for looking up every utxo the
+// pool has filed under a given mix address, e.g. to answer "what
+// happened to the extra UTXO I sent" without reading the database
+// directly.
+func poolHandler(w http.ResponseWriter, r *http.Request) {
+	addr := r.URL.Query().Get("addr")
+	if addr == "" {
+		http.Error(w, "missing addr", http.StatusBadRequest)
+		return
+	}
+
+	writeJson(w, &poolResponse{Utxos: pool.UtxosForAddr(addr)})
 }
 
 func HandleShutdown() {
@@ -62,6 +175,8 @@ func HandleShutdown() {
 func shutdown() {
 	// do we need to rpc.Disconnect()?
 	stopping = true
+	wsCtx.Shutdown()
+	log.Printf("shutdown websocket notifications")
 	mix.Shutdown()
 	log.Printf("shutdown mix")
 	pool.Shutdown()
@@ -71,22 +186,26 @@ func shutdown() {
 	os.Exit(0)
 }
 
-func handleChunkRequest(chunkMsg *ChunkMessage) error {
+// handleChunkRequest fills out and signs chunkMsg, logs it to the
+// accountability log, and returns the hex-encoded leaf hash under which
+// it was logged, so the caller can hand it back to the client as the
+// leafHash query param for /inclusion-proof.
+func handleChunkRequest(chunkMsg *ChunkMessage) (string, error) {
 	if stopping {
-		return errors.New("refused request; mixcoin shutting down")
+		return "", errors.New("refused request; mixcoin shutting down")
 	}
 	log.Printf("handling chunk request: %+v", chunkMsg)
 
 	err := validateChunkMsg(chunkMsg)
 	if err != nil {
 		log.Printf("Invalid chunk request: %v", err)
-		return err
+		return "", err
 	}
 
 	addr, err := getNewAddress()
 	if err != nil {
 		log.Panicf("Unable to create new address: %v", err)
-		return err
+		return "", err
 	}
 
 	encodedAddr := addr.EncodeAddress()
@@ -95,8 +214,27 @@ func handleChunkRequest(chunkMsg *ChunkMessage) error {
 	chunkMsg.MixAddr = encodedAddr
 
 	signChunkMessage(chunkMsg)
+
+	warrantyBytes, err := json.Marshal(chunkMsg)
+	if err != nil {
+		log.Panicf("error serializing warranty for accountability log: %v", err)
+	}
+	if _, err := auditLog.Append(warrantyBytes); err != nil {
+		log.Panicf("error appending to accountability log: %v", err)
+	}
+
 	registerNewChunk(encodedAddr, chunkMsg)
-	return nil
+	wsCtx.LinkWarrantyToken(encodedAddr, warrantyToken(warrantyBytes))
+	return hex.EncodeToString(WarrantyLeafHash(warrantyBytes)), nil
+}
+
+// warrantyToken derives the secret a client subscribes to this chunk's
+// websocket notifications with from the signed warranty itself, so only
+// whoever received this particular chunk response can scope a
+// subscription to it.
+func warrantyToken(warrantyBytes []byte) string {
+	sum := sha256.Sum256(warrantyBytes)
+	return hex.EncodeToString(sum[:])
 }
 
 func registerNewChunk(encodedAddr string, chunkMsg *ChunkMessage) {
@@ -117,7 +255,11 @@ func onBlockConnected(blockHash *btcwire.ShaHash, height int32) {
 func prune() {
 	pool.Filter(func(item PoolItem) bool {
 		msg := item.(*ChunkMessage)
-		return msg.SendBy <= blockchainHeight
+		expired := msg.SendBy <= blockchainHeight
+		if expired {
+			wsCtx.notifyChunkExpired(msg.MixAddr)
+		}
+		return expired
 	})
 }
 
@@ -134,6 +276,7 @@ func findTransactions(blockHash *btcwire.ShaHash, height int) {
 		decoded, err := decodeAddress(addr)
 		if err != nil {
 			log.Printf("error decoding address: %v", err)
+			continue
 		}
 		receivableAddrs = append(receivableAddrs, decoded)
 	}
@@ -144,10 +287,11 @@ func findTransactions(blockHash *btcwire.ShaHash, height int) {
 	}
 	log.Printf("received transactions: %+v", receivedByAddress)
 
-	// make addr -> utxo map of received txs
-	received := make(map[string]*Utxo)
+	// group every confirmed unspent output by address; an address may
+	// have more than one (overpayment, dust spam, or address reuse)
+	receivedByAddr := make(map[string][]*Utxo)
 	for _, result := range receivedByAddress {
-		if !isValidReceivedResult(result) {
+		if !isConfirmed(result) {
 			continue
 		}
 
@@ -156,16 +300,17 @@ func findTransactions(blockHash *btcwire.ShaHash, height int) {
 			log.Panicf("invalid tx amount: %v", err)
 		}
 
-		received[result.Address] = &Utxo{
-			Addr:   result.Address,
-			Amount: amount,
-			TxId:   result.TxId,
-			Index:  int(result.Vout),
+		utxo := &Utxo{
+			Outpoint: Outpoint{TxId: result.TxId, Vout: int(result.Vout)},
+			Addr:     result.Address,
+			Amount:   amount,
+			Height:   height,
 		}
+		receivedByAddr[result.Address] = append(receivedByAddr[result.Address], utxo)
 	}
 
 	var receivedAddrs []string
-	for addr, _ := range received {
+	for addr := range receivedByAddr {
 		receivedAddrs = append(receivedAddrs, addr)
 	}
 
@@ -176,25 +321,63 @@ func findTransactions(blockHash *btcwire.ShaHash, height int) {
 	log.Printf("received for chunkmessages %+v", chunkMsgs)
 	for _, item := range chunkMsgs {
 		msg := item.(*ChunkMessage)
-		utxo := received[msg.MixAddr]
-		if isFee(msg.Nonce, blockHash, msg.Fee) {
-			log.Printf("retaining as fee utxo %v", utxo)
-			pool.Put(Reserve, utxo)
+
+		fulfilling, extras := selectFulfillingUtxo(receivedByAddr[msg.MixAddr])
+		if fulfilling == nil {
+			log.Printf("no single utxo at %s satisfies the chunk size yet, leaving receivable", msg.MixAddr)
+			pool.Put(Receivable, msg)
+			continue
+		}
+
+		wsCtx.notifyChunkReceived(msg.MixAddr, fulfilling)
+
+		if isFee(msg.Nonce, blockHash, fulfilling.Outpoint, msg.Fee) {
+			log.Printf("retaining as fee utxo %v", fulfilling)
+			pool.Put(Reserve, fulfilling)
+			wsCtx.notifyChunkRetainedAsFee(msg.MixAddr, fulfilling)
 		} else {
 			log.Printf("mixing utxo for message: %v", msg)
-			pool.Put(Mixing, utxo)
+			pool.Put(Mixing, fulfilling)
 			mix.Put(msg)
+			wsCtx.notifyChunkMixing(msg.MixAddr, generateDelay(msg.SendBy), msg.SendBy)
 		}
+
+		routeExtraUtxos(msg.MixAddr, extras)
 	}
 	log.Printf("done handling block")
 }
 
-func isFee(nonce int64, hash *btcwire.ShaHash, feeBips int) bool {
-	bigIntHash := big.NewInt(0)
-	bigIntHash.SetBytes(hash.Bytes())
-	hashInt := bigIntHash.Int64()
+// selectFulfillingUtxo returns the first utxo that alone satisfies
+// cfg.ChunkSize, along with every other utxo received at the same
+// address, which the caller reserves as fees via routeExtraUtxos.
+func selectFulfillingUtxo(utxos []*Utxo) (fulfilling *Utxo, extras []*Utxo) {
+	for _, utxo := range utxos {
+		if fulfilling == nil && int64(utxo.Amount) >= cfg.ChunkSize {
+			fulfilling = utxo
+			continue
+		}
+		extras = append(extras, utxo)
+	}
+	return fulfilling, extras
+}
+
+// routeExtraUtxos reserves UTXOs received at addr beyond the one that
+// fulfilled the chunk. There's no record of who funded addr beyond the
+// mix address itself, so there's nowhere meaningful to refund these to;
+// treating them as fees avoids paying a transaction fee to send them
+// right back to the address they're already sitting at.
+func routeExtraUtxos(addr string, extras []*Utxo) {
+	for _, utxo := range extras {
+		log.Printf("reserving extra utxo %v at %s", utxo, addr)
+		pool.Put(Reserve, utxo)
+	}
+}
 
-	gen := nonce | hashInt
+// isFee flips a coin keyed on the chunk's nonce, the block hash, and the
+// specific outpoint being evaluated, so that two UTXOs landing on the
+// same address don't get correlated fee decisions.
+func isFee(nonce int64, hash *btcwire.ShaHash, outpoint Outpoint, feeBips int) bool {
+	gen := combineSeed(nonce, hash, outpoint)
 	fee := float64(feeBips) * 1.0e-4
 
 	source := rand.NewSource(gen)
@@ -202,17 +385,22 @@ func isFee(nonce int64, hash *btcwire.ShaHash, feeBips int) bool {
 	return rng.Float64() <= fee
 }
 
-func isValidReceivedResult(result btcjson.ListUnspentResult) bool {
-	// ListUnspentResult.Amount is a float64 in BTC
-	// btcutil.Amount is an int64
-	amountReceived, err := btcutil.NewAmount(result.Amount)
-	if err != nil {
-		log.Printf("error parsing amount received: %v", err)
-	}
-	amountReceivedInt := int64(amountReceived)
+// combineSeed hashes nonce, hash, and outpoint together into a single
+// seed. Combining independent values with OR or AND biases the result
+// toward all-ones or all-zeros, so hash them instead.
+func combineSeed(nonce int64, hash *btcwire.ShaHash, outpoint Outpoint) int64 {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(nonce))
 
-	hasConfirmations := result.Confirmations >= int64(cfg.MinConfirmations)
-	hasAmount := amountReceivedInt >= cfg.ChunkSize
+	h := sha256.New()
+	h.Write(buf)
+	h.Write(hash.Bytes())
+	h.Write([]byte(fmt.Sprintf("%s:%d", outpoint.TxId, outpoint.Vout)))
+	sum := h.Sum(nil)
+
+	return int64(binary.BigEndian.Uint64(sum[:8]))
+}
 
-	return hasConfirmations && hasAmount
+func isConfirmed(result btcjson.ListUnspentResult) bool {
+	return result.Confirmations >= int64(cfg.MinConfirmations)
 }
@@ -0,0 +1,138 @@
+package mixcoin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ensureTlsKeyPair makes sure a TLS certificate/key pair exists at certFile
+// and keyFile, generating a self-signed ECDSA P-256 pair on the fly if
+// either file is missing.
+func ensureTlsKeyPair(certFile, keyFile string) error {
+	if fileExists(certFile) && fileExists(keyFile) {
+		return nil
+	}
+
+	log.Printf("generating self-signed TLS keypair (cert=%s, key=%s)", certFile, keyFile)
+	return genCertPair(certFile, keyFile)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func genCertPair(certFile, keyFile string) error {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("error generating TLS key: %v", err)
+	}
+
+	serialLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(rand.Reader, serialLimit)
+	if err != nil {
+		return fmt.Errorf("error generating serial number: %v", err)
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("error looking up hostname: %v", err)
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{"mixcoin"},
+		},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(10 * 365 * 24 * time.Hour),
+
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+
+		DNSNames: []string{host, "localhost"},
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return fmt.Errorf("error enumerating interfaces: %v", err)
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() {
+			continue
+		}
+		template.IPAddresses = append(template.IPAddresses, ipNet.IP)
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		return fmt.Errorf("error creating certificate: %v", err)
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return fmt.Errorf("error marshaling private key: %v", err)
+	}
+
+	if err := writePem(certFile, "CERTIFICATE", derBytes); err != nil {
+		return err
+	}
+	if err := writePem(keyFile, "EC PRIVATE KEY", keyBytes); err != nil {
+		os.Remove(certFile)
+		return err
+	}
+
+	return nil
+}
+
+func writePem(path, blockType string, der []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening %s for writing: %v", path, err)
+	}
+	defer f.Close()
+
+	return pem.Encode(f, &pem.Block{Type: blockType, Bytes: der})
+}
+
+// NewChunkClient returns an *http.Client suitable for talking to the
+// chunk-request endpoint. When pinCertFile is non-empty, the client trusts
+// only that certificate; otherwise it performs normal CA verification,
+// unless insecureSkipVerify is set (for talking to a server running on a
+// self-signed cert whose fingerprint the caller doesn't have yet).
+func NewChunkClient(pinCertFile string, insecureSkipVerify bool) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify && pinCertFile == ""}
+
+	if pinCertFile != "" {
+		pemBytes, err := os.ReadFile(pinCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading pinned cert %s: %v", pinCertFile, err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemBytes) {
+			return nil, fmt.Errorf("no certificates found in %s", pinCertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
@@ -0,0 +1,76 @@
+package mixcoin
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestEnsureTlsKeyPair verifies that a missing cert/key pair is
+// generated as a valid self-signed certificate, and that a second call
+// leaves an already-existing pair untouched.
+func TestEnsureTlsKeyPair(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "rpc.cert")
+	keyFile := filepath.Join(dir, "rpc.key")
+
+	if err := ensureTlsKeyPair(certFile, keyFile); err != nil {
+		t.Fatalf("ensureTlsKeyPair: %v", err)
+	}
+
+	certPem, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatalf("reading generated cert: %v", err)
+	}
+	block, _ := pem.Decode(certPem)
+	if block == nil || block.Type != "CERTIFICATE" {
+		t.Fatalf("generated cert is not a PEM certificate block")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("parsing generated cert: %v", err)
+	}
+	if !cert.IsCA || len(cert.DNSNames) == 0 {
+		t.Fatalf("generated cert missing expected fields: %+v", cert)
+	}
+
+	if err := ensureTlsKeyPair(certFile, keyFile); err != nil {
+		t.Fatalf("ensureTlsKeyPair on existing pair: %v", err)
+	}
+	certPemAgain, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatalf("reading cert after second call: %v", err)
+	}
+	if string(certPem) != string(certPemAgain) {
+		t.Fatalf("ensureTlsKeyPair regenerated an already-existing keypair")
+	}
+}
+
+// TestNewChunkClientPinnedCert verifies that pinning a cert file
+// succeeds, and that a missing or empty cert file is rejected.
+func TestNewChunkClientPinnedCert(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "rpc.cert")
+	keyFile := filepath.Join(dir, "rpc.key")
+	if err := ensureTlsKeyPair(certFile, keyFile); err != nil {
+		t.Fatalf("ensureTlsKeyPair: %v", err)
+	}
+
+	if _, err := NewChunkClient(certFile, false); err != nil {
+		t.Fatalf("NewChunkClient with pinned cert: %v", err)
+	}
+
+	if _, err := NewChunkClient(filepath.Join(dir, "missing.cert"), false); err == nil {
+		t.Fatalf("NewChunkClient with missing cert file should have failed")
+	}
+
+	emptyFile := filepath.Join(dir, "empty.cert")
+	if err := os.WriteFile(emptyFile, nil, 0600); err != nil {
+		t.Fatalf("writing empty cert file: %v", err)
+	}
+	if _, err := NewChunkClient(emptyFile, false); err == nil {
+		t.Fatalf("NewChunkClient with a cert file containing no certificates should have failed")
+	}
+}
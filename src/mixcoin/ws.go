@@ -0,0 +1,243 @@
+package mixcoin
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"code.google.com/p/go.net/websocket"
+)
+
+// wsNotification is the message pushed to subscribers.
+type wsNotification struct {
+	Method string      `json:"method"`
+	Params interface{} `json:"params"`
+}
+
+type chunkReceivedParams struct {
+	Utxo *Utxo `json:"utxo"`
+}
+
+type chunkRetainedAsFeeParams struct {
+	Utxo *Utxo `json:"utxo"`
+}
+
+type chunkMixingParams struct {
+	Delay    int `json:"delay"`
+	ReturnBy int `json:"returnBy"`
+}
+
+type chunkSentParams struct {
+	OutAddr string `json:"outAddr"`
+	TxId    string `json:"txid"`
+	Vout    int    `json:"vout"`
+}
+
+type chunkExpiredParams struct{}
+
+// wsSubscribeMsg is sent once by the client immediately after the
+// websocket upgrade to say which chunk(s) it wants to hear about.
+type wsSubscribeMsg struct {
+	MixAddr       string `json:"mixAddr"`
+	WarrantyToken string `json:"warrantyToken"`
+}
+
+type wsClient struct {
+	conn          *websocket.Conn
+	send          chan wsNotification
+	mixAddr       string
+	warrantyToken string
+}
+
+// wsContext tracks subscribed websocket clients and fans chunk lifecycle
+// notifications out to whichever clients are listening for them, by mix
+// address or by the per-warranty token tied to that address in addrToken.
+type wsContext struct {
+	mu          sync.Mutex
+	byAddr      map[string][]*wsClient
+	byToken     map[string][]*wsClient
+	addrToken   map[string]string
+	authTimeout time.Duration
+	done        chan struct{}
+}
+
+func NewWsContext(authTimeoutSeconds int) *wsContext {
+	return &wsContext{
+		byAddr:      make(map[string][]*wsClient),
+		byToken:     make(map[string][]*wsClient),
+		addrToken:   make(map[string]string),
+		authTimeout: time.Duration(authTimeoutSeconds) * time.Second,
+		done:        make(chan struct{}),
+	}
+}
+
+// Handler returns a websocket.Handler suitable for mounting on the chunk
+// request HTTP mux.
+func (ctx *wsContext) Handler() websocket.Handler {
+	return websocket.Handler(ctx.handle)
+}
+
+func (ctx *wsContext) handle(conn *websocket.Conn) {
+	client := &wsClient{conn: conn, send: make(chan wsNotification, 16)}
+
+	if !ctx.authenticate(client) {
+		conn.Close()
+		return
+	}
+	defer ctx.remove(client)
+
+	go ctx.writeLoop(client)
+	ctx.readLoop(client)
+}
+
+// authenticate blocks until the client sends a subscribe message, the
+// auth timeout elapses, or the server is shutting down.
+func (ctx *wsContext) authenticate(client *wsClient) bool {
+	subscribed := make(chan bool, 1)
+	go func() {
+		var sub wsSubscribeMsg
+		if err := websocket.JSON.Receive(client.conn, &sub); err != nil {
+			subscribed <- false
+			return
+		}
+		client.mixAddr = sub.MixAddr
+		client.warrantyToken = sub.WarrantyToken
+		subscribed <- sub.MixAddr != "" || sub.WarrantyToken != ""
+	}()
+
+	select {
+	case ok := <-subscribed:
+		if ok {
+			ctx.register(client)
+		}
+		return ok
+	case <-time.After(ctx.authTimeout):
+		log.Printf("websocket client did not subscribe within %v, closing", ctx.authTimeout)
+		return false
+	case <-ctx.done:
+		return false
+	}
+}
+
+func (ctx *wsContext) register(client *wsClient) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if client.mixAddr != "" {
+		ctx.byAddr[client.mixAddr] = append(ctx.byAddr[client.mixAddr], client)
+	}
+	if client.warrantyToken != "" {
+		ctx.byToken[client.warrantyToken] = append(ctx.byToken[client.warrantyToken], client)
+	}
+}
+
+func (ctx *wsContext) remove(client *wsClient) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	removeFromIndex(ctx.byAddr, client.mixAddr, client)
+	removeFromIndex(ctx.byToken, client.warrantyToken, client)
+	close(client.send)
+}
+
+func removeFromIndex(index map[string][]*wsClient, key string, client *wsClient) {
+	if key == "" {
+		return
+	}
+	clients := index[key]
+	for i, c := range clients {
+		if c == client {
+			index[key] = append(clients[:i], clients[i+1:]...)
+			return
+		}
+	}
+}
+
+func (ctx *wsContext) writeLoop(client *wsClient) {
+	for {
+		select {
+		case msg, ok := <-client.send:
+			if !ok {
+				return
+			}
+			if err := websocket.JSON.Send(client.conn, msg); err != nil {
+				return
+			}
+		case <-ctx.done:
+			client.conn.Close()
+			return
+		}
+	}
+}
+
+// readLoop just blocks on the connection so handle() notices when the
+// client (or the network) goes away; clients aren't expected to send
+// anything after subscribing.
+func (ctx *wsContext) readLoop(client *wsClient) {
+	var discard interface{}
+	for {
+		if err := websocket.JSON.Receive(client.conn, &discard); err != nil {
+			return
+		}
+	}
+}
+
+// LinkWarrantyToken associates mixAddr with the token scoped to its
+// notifications, so a client subscribing by token never sees another
+// chunk's lifecycle.
+func (ctx *wsContext) LinkWarrantyToken(mixAddr, token string) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.addrToken[mixAddr] = token
+}
+
+func (ctx *wsContext) notify(mixAddr string, n wsNotification) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	recipients := make(map[*wsClient]bool)
+	for _, client := range ctx.byAddr[mixAddr] {
+		recipients[client] = true
+	}
+	if token, ok := ctx.addrToken[mixAddr]; ok {
+		for _, client := range ctx.byToken[token] {
+			recipients[client] = true
+		}
+	}
+
+	for client := range recipients {
+		select {
+		case client.send <- n:
+		default:
+			log.Printf("dropping %s notification for slow websocket client on %s", n.Method, mixAddr)
+		}
+	}
+}
+
+func (ctx *wsContext) notifyChunkReceived(mixAddr string, utxo *Utxo) {
+	ctx.notify(mixAddr, wsNotification{Method: "chunkReceived", Params: chunkReceivedParams{Utxo: utxo}})
+}
+
+func (ctx *wsContext) notifyChunkRetainedAsFee(mixAddr string, utxo *Utxo) {
+	ctx.notify(mixAddr, wsNotification{Method: "chunkRetainedAsFee", Params: chunkRetainedAsFeeParams{Utxo: utxo}})
+}
+
+func (ctx *wsContext) notifyChunkMixing(mixAddr string, delay, returnBy int) {
+	ctx.notify(mixAddr, wsNotification{Method: "chunkMixing", Params: chunkMixingParams{Delay: delay, ReturnBy: returnBy}})
+}
+
+func (ctx *wsContext) notifyChunkSent(mixAddr, outAddr, txId string, vout int) {
+	ctx.notify(mixAddr, wsNotification{Method: "chunkSent", Params: chunkSentParams{OutAddr: outAddr, TxId: txId, Vout: vout}})
+}
+
+func (ctx *wsContext) notifyChunkExpired(mixAddr string) {
+	ctx.notify(mixAddr, wsNotification{Method: "chunkExpired", Params: chunkExpiredParams{}})
+}
+
+// Shutdown closes every open websocket connection and stops accepting
+// new ones. Safe to call more than once.
+func (ctx *wsContext) Shutdown() {
+	select {
+	case <-ctx.done:
+	default:
+		close(ctx.done)
+	}
+}